@@ -5,88 +5,247 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/n8maninger/hostd-pin/control"
+	"github.com/n8maninger/hostd-pin/pin"
 	"github.com/n8maninger/hostd-pin/rate"
 	"github.com/shopspring/decimal"
-	"go.sia.tech/core/types"
-	"go.sia.tech/hostd/api"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v3"
 )
 
+// defaultConfig returns the config defaults applied before the config
+// file is loaded, both at startup and on every reload.
+func defaultConfig() Config {
+	return Config{
+		Currency:  "usd",
+		Threshold: decimal.NewFromFloat(0.1),
+		Frequency: 5 * time.Minute,
+		Prices: pin.Prices{
+			Storage:           decimal.NewFromFloat(1.00),
+			Ingress:           decimal.NewFromFloat(0.10),
+			Egress:            decimal.NewFromFloat(10),
+			Collateral:        decimal.NewFromFloat(2.00),
+			MaxCollateral:     decimal.NewFromFloat(5000),
+			ContractPrice:     decimal.NewFromFloat(0.10),
+			BaseRPCPrice:      decimal.NewFromFloat(0.0001),
+			SectorAccessPrice: decimal.NewFromFloat(0.0001),
+		},
+	}
+}
+
 type (
-	// Prices are the target prices for the host
-	Prices struct {
-		Storage decimal.Decimal `json:"storage"`
-		Ingress decimal.Decimal `json:"ingress"`
-		Egress  decimal.Decimal `json:"egress"`
+	// SourceConfig configures one of the upstream exchange rate sources
+	// the rate averager queries on each tick.
+	SourceConfig struct {
+		// Type selects the source implementation: "siacentral",
+		// "coingecko", "kraken", or "http".
+		Type    string          `yaml:"type"`
+		Enabled bool            `yaml:"enabled"`
+		Weight  decimal.Decimal `yaml:"weight"`
+
+		// Pair is the trading pair to query. Only used by the "kraken"
+		// source, e.g. "SCUSD".
+		Pair string `yaml:"pair,omitempty"`
+		// URL is the endpoint to query. Only used by the "http" source.
+		URL string `yaml:"url,omitempty"`
+		// Field is the JSONPath-style selector identifying the price
+		// field in the response body. Only used by the "http" source.
+		Field string `yaml:"field,omitempty"`
 	}
 
-	// Host is a host that should have its prices updated.
-	Host struct {
-		Address  string `yaml:"address"`
-		Password string `yaml:"password"`
+	// StoreConfig configures the persistent store used to retain rate
+	// history across restarts.
+	StoreConfig struct {
+		// Type selects the store implementation: "sqlite", "bolt", or
+		// "" to keep history in memory only.
+		Type string `yaml:"type"`
+		Path string `yaml:"path"`
+	}
+
+	// StrategyConfig configures the moving-average strategy used to
+	// smooth the exchange rate.
+	StrategyConfig struct {
+		// Type selects the strategy implementation: "sma" (default),
+		// "ema", "vwap", or "twap".
+		Type string `yaml:"type"`
+		// Alpha is the smoothing factor used by the "ema" strategy.
+		Alpha decimal.Decimal `yaml:"alpha"`
+		// Days is the window size, in days, used by the "twap"
+		// strategy.
+		Days int `yaml:"days"`
+		// SourceWeights maps source name to confidence weight, used by
+		// the "vwap" strategy.
+		SourceWeights map[string]decimal.Decimal `yaml:"sourceWeights"`
 	}
 
 	// Config is the configuration for the hostd-pin application.
 	Config struct {
-		Hosts     []Host          `yaml:"hosts"`
-		Prices    Prices          `yaml:"prices"`
+		Hosts     []pin.Host      `yaml:"hosts"`
+		Sources   []SourceConfig  `yaml:"sources"`
+		Store     StoreConfig     `yaml:"store"`
+		Strategy  StrategyConfig  `yaml:"strategy"`
+		Window    time.Duration   `yaml:"window"`
+		Prices    pin.Prices      `yaml:"prices"`
 		Currency  string          `yaml:"currency"`
 		Frequency time.Duration   `yaml:"frequency"`
 		Threshold decimal.Decimal `yaml:"threshold"`
+		// Listen is the address the control API listens on. If empty,
+		// the control API is disabled.
+		Listen string `yaml:"listen"`
+
+		// MinPrices and MaxPrices clamp the fiat target prices pushed
+		// to any host, regardless of the configured target or any
+		// per-host override.
+		MinPrices pin.Prices `yaml:"minPrices"`
+		MaxPrices pin.Prices `yaml:"maxPrices"`
+		// MaxChangePercent, if non-zero, rejects (and skips) any
+		// single update that would move a host's on-chain price by
+		// more than this percentage.
+		MaxChangePercent decimal.Decimal `yaml:"maxChangePercent"`
+		// DryRun, if true, logs what would be pushed to each host
+		// without ever calling UpdateSettings.
+		DryRun bool `yaml:"dryRun"`
+		// MinRateSamples is the number of exchange rate ticks that
+		// must be collected before the pin loop will push an update.
+		MinRateSamples int `yaml:"minRateSamples"`
 	}
 )
 
-func isOverThreshold(a, b, percentage decimal.Decimal) bool {
-	threshold := a.Mul(percentage)
-	diff := a.Sub(b).Abs()
-	return diff.GreaterThan(threshold)
-}
+// buildSources converts the configured sources into the weighted sources
+// consumed by the rate averager. If cfgs is empty, it falls back to a
+// single SiaCentral source so existing configs keep working unchanged.
+func buildSources(cfgs []SourceConfig) ([]rate.WeightedSource, error) {
+	if len(cfgs) == 0 {
+		return []rate.WeightedSource{{Source: rate.SiaCentralSource{}, Weight: decimal.NewFromInt(1)}}, nil
+	}
 
-func convertToCurrency(target decimal.Decimal, rate decimal.Decimal) types.Currency {
-	hastings := target.Div(rate).Mul(decimal.New(1, 24)).Round(0).String()
-	c, err := types.ParseCurrency(hastings)
-	if err != nil {
-		panic(err)
+	var sources []rate.WeightedSource
+	for _, c := range cfgs {
+		if !c.Enabled {
+			continue
+		}
+
+		var src rate.Source
+		switch c.Type {
+		case "siacentral":
+			src = rate.SiaCentralSource{}
+		case "coingecko":
+			src = rate.CoinGeckoSource{}
+		case "kraken":
+			if c.Pair == "" {
+				return nil, fmt.Errorf("kraken source requires a pair")
+			}
+			src = rate.KrakenSource{Pair: c.Pair}
+		case "http":
+			if c.URL == "" || c.Field == "" {
+				return nil, fmt.Errorf("http source requires a url and field")
+			}
+			src = rate.HTTPSource{URL: c.URL, Field: c.Field}
+		default:
+			return nil, fmt.Errorf("unknown source type %q", c.Type)
+		}
+
+		weight := c.Weight
+		if weight.IsZero() {
+			weight = decimal.NewFromInt(1)
+		}
+		sources = append(sources, rate.WeightedSource{Source: src, Weight: weight})
 	}
-	return c
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no sources enabled")
+	}
+	return sources, nil
 }
 
-func updateHosts(hosts []Host, target Prices, rate decimal.Decimal, log *zap.Logger) error {
-	storagePrice := convertToCurrency(target.Storage, rate).Div64(4320).Div64(1e12)
-	ingressPrice := convertToCurrency(target.Ingress, rate).Div64(1e12)
-	egressPrice := convertToCurrency(target.Egress, rate).Div64(1e12)
-
-	log = log.With(zap.Stringer("rate", rate), zap.Stringer("storage", storagePrice), zap.Stringer("ingress", ingressPrice), zap.Stringer("egress", egressPrice))
+// buildStore opens the configured persistent rate store. If cfg.Type is
+// empty, it returns a nil Store and the averager keeps history in memory
+// only.
+func buildStore(cfg StoreConfig) (rate.Store, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "sqlite":
+		return rate.NewSQLiteStore(cfg.Path)
+	case "bolt":
+		return rate.NewBoltStore(cfg.Path)
+	default:
+		return nil, fmt.Errorf("unknown store type %q", cfg.Type)
+	}
+}
 
-	for _, h := range hosts {
-		client := api.NewClient(h.Address, h.Password)
-		_, err := client.UpdateSettings(api.SetMinStoragePrice(storagePrice), api.SetMinIngressPrice(ingressPrice), api.SetMinEgressPrice(egressPrice))
-		if err != nil {
-			return fmt.Errorf("failed to update host %q: %w", h.Address, err)
+// buildStrategy constructs the moving-average strategy used to smooth
+// the exchange rate. If cfg.Type is empty, it defaults to SMAStrategy.
+func buildStrategy(cfg StrategyConfig, store rate.Store) (rate.Strategy, error) {
+	switch cfg.Type {
+	case "", "sma":
+		return rate.SMAStrategy{}, nil
+	case "ema":
+		if cfg.Alpha.IsZero() {
+			return nil, fmt.Errorf("ema strategy requires an alpha")
+		}
+		return rate.EMAStrategy{Alpha: cfg.Alpha}, nil
+	case "vwap":
+		return rate.VWAPStrategy{SourceWeights: cfg.SourceWeights}, nil
+	case "twap":
+		if store == nil {
+			return nil, fmt.Errorf("twap strategy requires a store")
+		}
+		if cfg.Days <= 0 {
+			return nil, fmt.Errorf("twap strategy requires a positive number of days")
 		}
-		log.Debug("updated host", zap.String("host", h.Address))
+		return rate.TWAPStrategy{Store: store, Days: cfg.Days}, nil
+	default:
+		return nil, fmt.Errorf("unknown strategy type %q", cfg.Type)
 	}
-	return nil
 }
 
-func mustLoadConfig(configPath string, cfg *Config) {
+// loadConfig reads and decodes the config file at configPath into cfg,
+// returning an error instead of panicking so a failed reload doesn't take
+// down an already-running daemon.
+func loadConfig(configPath string, cfg *Config) error {
 	f, err := os.Open(configPath)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	defer f.Close()
 
 	dec := yaml.NewDecoder(f)
 	dec.KnownFields(true)
+	return dec.Decode(cfg)
+}
+
+// buildRateOptions assembles the rate.Options used to construct the
+// exchange rate averager, shared between the initial startup and every
+// config reload that reconstructs the averager.
+func buildRateOptions(cfg Config, sources []rate.WeightedSource, strategy rate.Strategy, store rate.Store, log *zap.Logger) []rate.Option {
+	opts := []rate.Option{
+		rate.WithCurrency(cfg.Currency),
+		rate.WithFrequency(cfg.Frequency),
+		rate.WithSources(sources...),
+		rate.WithStrategy(strategy),
+		rate.WithLogger(log),
+	}
+	if store != nil {
+		opts = append(opts, rate.WithStore(store))
+	}
+	if cfg.Window != 0 {
+		opts = append(opts, rate.WithWindow(cfg.Window))
+	}
+	if cfg.MinRateSamples != 0 {
+		opts = append(opts, rate.WithMinSamples(cfg.MinRateSamples))
+	}
+	return opts
+}
 
-	if err := dec.Decode(&cfg); err != nil {
+func mustLoadConfig(configPath string, cfg *Config) {
+	if err := loadConfig(configPath, cfg); err != nil {
 		panic(err)
 	}
 }
@@ -96,18 +255,24 @@ func main() {
 	flag.StringVar(&configPath, "config", "config.yml", "path to the config file")
 	flag.Parse()
 
-	cfg := Config{
-		Currency:  "usd",
-		Threshold: decimal.NewFromFloat(0.1),
-		Frequency: 5 * time.Minute,
-		Prices: Prices{
-			Storage: decimal.NewFromFloat(1.00),
-			Ingress: decimal.NewFromFloat(0.10),
-			Egress:  decimal.NewFromFloat(10),
-		},
-	}
+	cfg := defaultConfig()
 	mustLoadConfig(configPath, &cfg)
 
+	sources, err := buildSources(cfg.Sources)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	store, err := buildStore(cfg.Store)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	strategy, err := buildStrategy(cfg.Strategy, store)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	// configure console logging note: this is configured before anything else
 	// to have consistent logging. File logging will be added after the cli
 	// flags and config is parsed
@@ -125,43 +290,46 @@ func main() {
 	logger := zap.New(consoleCore, zap.AddCaller())
 	defer logger.Sync()
 
-	r := rate.New(rate.WithCurrency(cfg.Currency),
-		rate.WithFrequency(cfg.Frequency),
-		rate.WithLogger(logger.Named("rate")))
+	if store != nil {
+		defer store.Close()
+	}
+	r := rate.New(buildRateOptions(cfg, sources, strategy, store, logger.Named("rate"))...)
+
+	guardrails := pin.Guardrails{
+		MinPrices:        cfg.MinPrices,
+		MaxPrices:        cfg.MaxPrices,
+		MaxChangePercent: cfg.MaxChangePercent,
+		DryRun:           cfg.DryRun,
+	}
+	pinner := pin.NewPinner(cfg.Hosts, cfg.Prices, cfg.Threshold, guardrails, r, logger.Named("pin"))
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill, syscall.SIGTERM)
 	defer cancel()
 
-	lastRate, err := r.Update()
-	if err != nil {
-		log.Panic("failed to get initial exchange rate", zap.Error(err))
-	}
-
-	// set the initial rate
-	if err = updateHosts(cfg.Hosts, cfg.Prices, lastRate, logger); err != nil {
-		logger.Error("failed to update hosts", zap.Error(err))
+	if _, err := pinner.Init(); err != nil {
+		log.Panic(err)
 	}
 
-	go r.Run(ctx)
+	rl := newReloader(ctx, configPath, cfg, store, pinner, logger.Named("reload"))
+	rl.startRate(r)
+	rl.startPinner(cfg.Frequency)
+	go rl.watch()
 
-	t := time.NewTicker(5 * time.Minute)
-	defer t.Stop()
-
-	for {
-		select {
-		case <-t.C:
-			average := r.Rate()
-			if !isOverThreshold(lastRate, average, cfg.Threshold) {
-				logger.Debug("skipping update", zap.Stringer("old", lastRate), zap.Stringer("new", average))
-				continue
-			}
-			lastRate = average
-			err := updateHosts(cfg.Hosts, cfg.Prices, average, logger)
-			if err != nil {
-				logger.Error("failed to update hosts", zap.Error(err))
+	if cfg.Listen != "" {
+		srv := &http.Server{Addr: cfg.Listen, Handler: control.NewServer(pinner, logger.Named("control")).Handler()}
+		go func() {
+			logger.Info("control API listening", zap.String("address", cfg.Listen))
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("control API failed", zap.Error(err))
 			}
-		case <-ctx.Done():
-			return
-		}
+		}()
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			srv.Shutdown(shutdownCtx)
+		}()
 	}
+
+	<-ctx.Done()
 }
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/n8maninger/hostd-pin/pin"
+	"github.com/n8maninger/hostd-pin/rate"
+	"go.uber.org/zap"
+)
+
+// reloader watches the config file for changes and applies validated
+// updates to a running daemon without requiring a restart. Hosts,
+// prices, threshold, and guardrails are applied in place; Currency and
+// Frequency changes reconstruct the rate.Averager and restart the pin
+// loop, since both are baked into the averager's ticker and history
+// window. An invalid reload is logged and discarded rather than
+// terminating the process.
+type reloader struct {
+	log        *zap.Logger
+	configPath string
+	parentCtx  context.Context
+	store      rate.Store
+	pinner     *pin.Pinner
+
+	cfg atomic.Pointer[Config]
+
+	mu         sync.Mutex // serializes reloads and guards cancelRate/cancelPin
+	cancelRate context.CancelFunc
+	cancelPin  context.CancelFunc
+}
+
+// newReloader creates a reloader for an already-running daemon. cfg is
+// the config currently in effect.
+func newReloader(parentCtx context.Context, configPath string, cfg Config, store rate.Store, pinner *pin.Pinner, log *zap.Logger) *reloader {
+	rl := &reloader{log: log, configPath: configPath, parentCtx: parentCtx, store: store, pinner: pinner}
+	rl.cfg.Store(&cfg)
+	return rl
+}
+
+// startRate replaces the Averager the Pinner reads from and starts its
+// Run loop, stopping the previous one first if any.
+func (rl *reloader) startRate(averager *rate.Averager) {
+	if rl.cancelRate != nil {
+		rl.cancelRate()
+	}
+	ctx, cancel := context.WithCancel(rl.parentCtx)
+	rl.cancelRate = cancel
+	rl.pinner.SetAverager(averager)
+	go averager.Run(ctx)
+}
+
+// startPinner (re)starts the Pinner's Run loop at frequency, stopping the
+// previous one first if any.
+func (rl *reloader) startPinner(frequency time.Duration) {
+	if rl.cancelPin != nil {
+		rl.cancelPin()
+	}
+	ctx, cancel := context.WithCancel(rl.parentCtx)
+	rl.cancelPin = cancel
+	go rl.pinner.Run(ctx, frequency)
+}
+
+// watch blocks, reloading the config whenever its file changes or the
+// process receives SIGHUP, until the reloader's parent context is done.
+// It watches the containing directory rather than the file itself, since
+// many editors and config management tools replace a file atomically via
+// rename rather than writing to it in place.
+func (rl *reloader) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		rl.log.Error("failed to start config watcher", zap.Error(err))
+		return
+	}
+	defer watcher.Close()
+
+	dir := filepath.Dir(rl.configPath)
+	if err := watcher.Add(dir); err != nil {
+		rl.log.Error("failed to watch config directory", zap.String("dir", dir), zap.Error(err))
+		return
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(rl.configPath) {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+				continue
+			}
+			rl.reload()
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			rl.log.Error("config watcher error", zap.Error(err))
+		case <-sigCh:
+			rl.log.Info("received SIGHUP, reloading config")
+			rl.reload()
+		case <-rl.parentCtx.Done():
+			return
+		}
+	}
+}
+
+// reload re-reads the config file, validates it, and applies any changes
+// to the running daemon. It rejects an invalid reload by logging the
+// error and leaving the daemon running on its previous config.
+func (rl *reloader) reload() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	prev := rl.cfg.Load()
+	next := defaultConfig()
+	if err := loadConfig(rl.configPath, &next); err != nil {
+		rl.log.Error("failed to reload config, keeping previous config", zap.Error(err))
+		return
+	}
+
+	sources, err := buildSources(next.Sources)
+	if err != nil {
+		rl.log.Error("invalid config reload, keeping previous config", zap.Error(err))
+		return
+	}
+	strategy, err := buildStrategy(next.Strategy, rl.store)
+	if err != nil {
+		rl.log.Error("invalid config reload, keeping previous config", zap.Error(err))
+		return
+	}
+
+	added, removed := diffHosts(prev.Hosts, next.Hosts)
+
+	rl.pinner.SetHosts(next.Hosts)
+	rl.pinner.SetPrices(next.Prices)
+	rl.pinner.SetThreshold(next.Threshold)
+	rl.pinner.SetGuardrails(pin.Guardrails{
+		MinPrices:        next.MinPrices,
+		MaxPrices:        next.MaxPrices,
+		MaxChangePercent: next.MaxChangePercent,
+		DryRun:           next.DryRun,
+	})
+
+	rateChanged := next.Currency != prev.Currency || next.Frequency != prev.Frequency
+	if rateChanged {
+		averager := rate.New(buildRateOptions(next, sources, strategy, rl.store, rl.log.Named("rate"))...)
+		rl.startRate(averager)
+		rl.startPinner(next.Frequency)
+	}
+
+	rl.cfg.Store(&next)
+	rl.log.Info("config reloaded",
+		zap.Bool("averagerReconstructed", rateChanged),
+		zap.Strings("hostsAdded", added),
+		zap.Strings("hostsRemoved", removed))
+}
+
+// diffHosts returns the addresses present in next but not prev, and the
+// addresses present in prev but not next.
+func diffHosts(prev, next []pin.Host) (added, removed []string) {
+	prevSet := make(map[string]bool, len(prev))
+	for _, h := range prev {
+		prevSet[h.Address] = true
+	}
+	nextSet := make(map[string]bool, len(next))
+	for _, h := range next {
+		nextSet[h.Address] = true
+		if !prevSet[h.Address] {
+			added = append(added, h.Address)
+		}
+	}
+	for _, h := range prev {
+		if !nextSet[h.Address] {
+			removed = append(removed, h.Address)
+		}
+	}
+	return added, removed
+}
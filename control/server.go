@@ -0,0 +1,160 @@
+// Package control implements an HTTP API for inspecting and operating a
+// running pin daemon: status, Prometheus metrics, and endpoints for
+// triggering a manual repin or hot-reloading target prices.
+package control
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/n8maninger/hostd-pin/pin"
+	"github.com/n8maninger/hostd-pin/rate"
+	"github.com/shopspring/decimal"
+	"go.sia.tech/core/types"
+	"go.uber.org/zap"
+)
+
+// Server exposes an HTTP control API backed by a pin.Pinner.
+type Server struct {
+	log    *zap.Logger
+	pinner *pin.Pinner
+}
+
+// NewServer creates a new control Server for pinner.
+func NewServer(pinner *pin.Pinner, log *zap.Logger) *Server {
+	return &Server{pinner: pinner, log: log}
+}
+
+// Handler returns the HTTP handler for the control API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("POST /repin", s.handleRepin)
+	mux.HandleFunc("GET /prices", s.handleGetPrices)
+	mux.HandleFunc("PUT /prices", s.handlePutPrices)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	mux.HandleFunc("GET /history", s.handleHistory)
+	return mux
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Error("failed to encode response", zap.Error(err))
+	}
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, s.pinner.Status())
+}
+
+func (s *Server) handleRepin(w http.ResponseWriter, r *http.Request) {
+	if err := s.pinner.Repin(); err != nil {
+		if errors.Is(err, rate.ErrInsufficientSamples) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleGetPrices(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, s.pinner.Prices())
+}
+
+func (s *Server) handlePutPrices(w http.ResponseWriter, r *http.Request) {
+	var p pin.Prices
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, fmt.Sprintf("invalid prices: %v", err), http.StatusBadRequest)
+		return
+	}
+	s.pinner.SetPrices(p)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleHistory returns persisted exchange rate samples for charting.
+// The optional "since" query parameter is an RFC3339 timestamp; if
+// omitted, the full retained history is returned.
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since: %v", err), http.StatusBadRequest)
+			return
+		}
+		since = parsed
+	}
+
+	history, err := s.pinner.RateHistory(since)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.writeJSON(w, history)
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	status := s.pinner.Status()
+	prices := s.pinner.Prices()
+
+	var buf bytes.Buffer
+	writeGauge(&buf, "hostd_pin_exchange_rate", "Current exchange rate for the configured currency.", status.Rate)
+	writeGauge(&buf, "hostd_pin_exchange_rate_average", "Smoothed average exchange rate.", status.Average)
+	writeGauge(&buf, "hostd_pin_target_storage_price", "Configured target storage price, fiat/TB-month.", prices.Storage)
+	writeGauge(&buf, "hostd_pin_target_ingress_price", "Configured target ingress price, fiat/TB.", prices.Ingress)
+	writeGauge(&buf, "hostd_pin_target_egress_price", "Configured target egress price, fiat/TB.", prices.Egress)
+	writeGauge(&buf, "hostd_pin_target_collateral_multiplier", "Configured target collateral multiplier, a ratio of the storage price.", prices.Collateral)
+	writeGauge(&buf, "hostd_pin_target_max_collateral", "Configured target max collateral, fiat/contract.", prices.MaxCollateral)
+	writeGauge(&buf, "hostd_pin_target_contract_price", "Configured target contract price, fiat/op.", prices.ContractPrice)
+	writeGauge(&buf, "hostd_pin_target_base_rpc_price", "Configured target base RPC price, fiat/op.", prices.BaseRPCPrice)
+	writeGauge(&buf, "hostd_pin_target_sector_access_price", "Configured target sector access price, fiat/op.", prices.SectorAccessPrice)
+
+	writeHastingsHelp(&buf, "hostd_pin_host_price_hastings", "Converted on-chain hastings price last pushed to a host, by resource.")
+	writeCounterHelp(&buf, "hostd_pin_host_update_total", "Total host price update attempts, by outcome.")
+	writeGaugeHelp(&buf, "hostd_pin_host_collateral_multiplier", "Collateral multiplier last pushed to a host, a ratio of the storage price.")
+	for _, h := range status.Hosts {
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "storage", h.Prices.Storage)
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "ingress", h.Prices.Ingress)
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "egress", h.Prices.Egress)
+		fmt.Fprintf(&buf, "hostd_pin_host_collateral_multiplier{host=%q} %s\n", h.Address, h.Prices.Collateral.String())
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "max_collateral", h.Prices.MaxCollateral)
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "contract_price", h.Prices.ContractPrice)
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "base_rpc_price", h.Prices.BaseRPCPrice)
+		writeHastingsGauge(&buf, "hostd_pin_host_price_hastings", h.Address, "sector_access_price", h.Prices.SectorAccessPrice)
+
+		fmt.Fprintf(&buf, "hostd_pin_host_update_total{host=%q,outcome=\"success\"} %d\n", h.Address, h.Successes)
+		fmt.Fprintf(&buf, "hostd_pin_host_update_total{host=%q,outcome=\"failure\"} %d\n", h.Address, h.Failures)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+func writeGauge(buf *bytes.Buffer, name, help string, v decimal.Decimal) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, v.String())
+}
+
+func writeHastingsHelp(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func writeGaugeHelp(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func writeCounterHelp(buf *bytes.Buffer, name, help string) {
+	fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+}
+
+func writeHastingsGauge(buf *bytes.Buffer, name, host, resource string, v types.Currency) {
+	f, _ := new(big.Float).SetInt(v.Big()).Float64()
+	fmt.Fprintf(buf, "%s{host=%q,resource=%q} %g\n", name, host, resource, f)
+}
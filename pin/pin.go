@@ -0,0 +1,506 @@
+// Package pin implements the core pin-daemon logic: translating a target
+// fiat pricing policy and a smoothed exchange rate into on-chain host
+// settings, and pushing them to a fleet of hostd nodes.
+package pin
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/n8maninger/hostd-pin/rate"
+	"github.com/shopspring/decimal"
+	"go.sia.tech/core/types"
+	"go.sia.tech/hostd/api"
+	"go.sia.tech/hostd/host/settings"
+	"go.uber.org/zap"
+)
+
+type (
+	// Prices are the target prices for a host. Storage is
+	// fiat/TB-month; Ingress and Egress are fiat/TB; MaxCollateral is a flat fiat cap per
+	// contract; ContractPrice, BaseRPCPrice, and SectorAccessPrice are
+	// flat fiat/op. Collateral is not a fiat price at all: it's hostd's
+	// CollateralMultiplier, a dimensionless ratio of the storage price,
+	// so it is configured directly and never passes through the
+	// exchange rate.
+	Prices struct {
+		Storage decimal.Decimal `json:"storage" yaml:"storage"`
+		Ingress decimal.Decimal `json:"ingress" yaml:"ingress"`
+		Egress  decimal.Decimal `json:"egress" yaml:"egress"`
+
+		Collateral        decimal.Decimal `json:"collateral" yaml:"collateral"`
+		MaxCollateral     decimal.Decimal `json:"maxCollateral" yaml:"maxCollateral"`
+		ContractPrice     decimal.Decimal `json:"contractPrice" yaml:"contractPrice"`
+		BaseRPCPrice      decimal.Decimal `json:"baseRPCPrice" yaml:"baseRPCPrice"`
+		SectorAccessPrice decimal.Decimal `json:"sectorAccessPrice" yaml:"sectorAccessPrice"`
+	}
+
+	// Host is a host that should have its prices updated. Prices, if
+	// set, overrides the daemon's default target prices for this host
+	// only, allowing a single daemon to pin a heterogeneous fleet.
+	Host struct {
+		Address  string  `yaml:"address"`
+		Password string  `yaml:"password"`
+		Prices   *Prices `yaml:"prices,omitempty"`
+	}
+
+	// ConvertedPrices holds a Prices target converted to on-chain
+	// hastings values for a given exchange rate. Collateral is the
+	// exception: it carries straight through as the dimensionless
+	// CollateralMultiplier ratio, since it was never a fiat value to
+	// convert.
+	ConvertedPrices struct {
+		Storage           types.Currency  `json:"storage"`
+		Ingress           types.Currency  `json:"ingress"`
+		Egress            types.Currency  `json:"egress"`
+		Collateral        decimal.Decimal `json:"collateral"`
+		MaxCollateral     types.Currency  `json:"maxCollateral"`
+		ContractPrice     types.Currency  `json:"contractPrice"`
+		BaseRPCPrice      types.Currency  `json:"baseRPCPrice"`
+		SectorAccessPrice types.Currency  `json:"sectorAccessPrice"`
+	}
+
+	// HostStatus reports the result of the most recent price update for
+	// a host.
+	HostStatus struct {
+		Address    string          `json:"address"`
+		LastUpdate time.Time       `json:"lastUpdate"`
+		Error      string          `json:"error,omitempty"`
+		Successes  uint64          `json:"successes"`
+		Failures   uint64          `json:"failures"`
+		Prices     ConvertedPrices `json:"prices"`
+	}
+
+	// Status is a snapshot of the pin daemon's current state.
+	Status struct {
+		Rate      decimal.Decimal `json:"rate"`
+		Average   decimal.Decimal `json:"average"`
+		RateReady bool            `json:"rateReady"`
+		Hosts     []HostStatus    `json:"hosts"`
+	}
+
+	// Guardrails bounds the prices Pinner is allowed to push to a host,
+	// independent of the configured target.
+	Guardrails struct {
+		// MinPrices and MaxPrices clamp the fiat target prices before
+		// conversion. A zero field in either disables that bound for
+		// the corresponding resource.
+		MinPrices Prices
+		MaxPrices Prices
+		// MaxChangePercent, if non-zero, rejects an update that would
+		// move any on-chain price by more than this percentage
+		// relative to the host's current settings.
+		MaxChangePercent decimal.Decimal
+		// DryRun, if true, logs what would have been pushed to each
+		// host without calling UpdateSettings.
+		DryRun bool
+	}
+)
+
+func isOverThreshold(a, b, percentage decimal.Decimal) bool {
+	threshold := a.Mul(percentage)
+	diff := a.Sub(b).Abs()
+	return diff.GreaterThan(threshold)
+}
+
+func convertToCurrency(target decimal.Decimal, rate decimal.Decimal) types.Currency {
+	hastings := target.Div(rate).Mul(decimal.New(1, 24)).Round(0).String()
+	c, err := types.ParseCurrency(hastings)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Convert translates a Prices target into on-chain hastings values for the
+// given exchange rate.
+func Convert(target Prices, rate decimal.Decimal) ConvertedPrices {
+	return ConvertedPrices{
+		Storage:           convertToCurrency(target.Storage, rate).Div64(4320).Div64(1e12),
+		Ingress:           convertToCurrency(target.Ingress, rate).Div64(1e12),
+		Egress:            convertToCurrency(target.Egress, rate).Div64(1e12),
+		Collateral:        target.Collateral,
+		MaxCollateral:     convertToCurrency(target.MaxCollateral, rate),
+		ContractPrice:     convertToCurrency(target.ContractPrice, rate),
+		BaseRPCPrice:      convertToCurrency(target.BaseRPCPrice, rate),
+		SectorAccessPrice: convertToCurrency(target.SectorAccessPrice, rate),
+	}
+}
+
+// clampDecimal restricts v to [lo, hi]. A zero lo or hi disables that
+// side of the bound.
+func clampDecimal(v, lo, hi decimal.Decimal) decimal.Decimal {
+	if !lo.IsZero() && v.LessThan(lo) {
+		return lo
+	}
+	if !hi.IsZero() && v.GreaterThan(hi) {
+		return hi
+	}
+	return v
+}
+
+// clampPrices clamps each field of target to the corresponding [min, max]
+// bound.
+func clampPrices(target, min, max Prices) Prices {
+	return Prices{
+		Storage:           clampDecimal(target.Storage, min.Storage, max.Storage),
+		Ingress:           clampDecimal(target.Ingress, min.Ingress, max.Ingress),
+		Egress:            clampDecimal(target.Egress, min.Egress, max.Egress),
+		Collateral:        clampDecimal(target.Collateral, min.Collateral, max.Collateral),
+		MaxCollateral:     clampDecimal(target.MaxCollateral, min.MaxCollateral, max.MaxCollateral),
+		ContractPrice:     clampDecimal(target.ContractPrice, min.ContractPrice, max.ContractPrice),
+		BaseRPCPrice:      clampDecimal(target.BaseRPCPrice, min.BaseRPCPrice, max.BaseRPCPrice),
+		SectorAccessPrice: clampDecimal(target.SectorAccessPrice, min.SectorAccessPrice, max.SectorAccessPrice),
+	}
+}
+
+// changePercent returns the absolute percentage change between old and
+// new currency values. A zero old value is treated as a 100% change,
+// unless new is also zero, which is no change.
+func changePercent(old, new types.Currency) decimal.Decimal {
+	oldDec := decimal.RequireFromString(old.String())
+	newDec := decimal.RequireFromString(new.String())
+	if oldDec.IsZero() {
+		if newDec.IsZero() {
+			return decimal.Zero
+		}
+		return decimal.NewFromInt(100)
+	}
+	return newDec.Sub(oldDec).Abs().Div(oldDec).Mul(decimal.NewFromInt(100))
+}
+
+// changePercentDecimal is the changePercent analogue for the collateral
+// multiplier, which is a dimensionless ratio rather than an on-chain
+// currency.
+func changePercentDecimal(old, new decimal.Decimal) decimal.Decimal {
+	if old.IsZero() {
+		if new.IsZero() {
+			return decimal.Zero
+		}
+		return decimal.NewFromInt(100)
+	}
+	return new.Sub(old).Abs().Div(old).Mul(decimal.NewFromInt(100))
+}
+
+// exceedsMaxChange reports whether any resource in next differs from
+// current by more than maxChangePercent.
+func exceedsMaxChange(current, next ConvertedPrices, maxChangePercent decimal.Decimal) bool {
+	if changePercentDecimal(current.Collateral, next.Collateral).GreaterThan(maxChangePercent) {
+		return true
+	}
+	for _, pair := range [][2]types.Currency{
+		{current.Storage, next.Storage},
+		{current.Ingress, next.Ingress},
+		{current.Egress, next.Egress},
+		{current.MaxCollateral, next.MaxCollateral},
+		{current.ContractPrice, next.ContractPrice},
+		{current.BaseRPCPrice, next.BaseRPCPrice},
+		{current.SectorAccessPrice, next.SectorAccessPrice},
+	} {
+		if changePercent(pair[0], pair[1]).GreaterThan(maxChangePercent) {
+			return true
+		}
+	}
+	return false
+}
+
+// Pinner periodically pins a fleet of hosts' prices to a target fiat
+// value using a smoothed exchange rate supplied by a rate.Averager.
+type Pinner struct {
+	log *zap.Logger
+
+	mu         sync.Mutex // protects the fields below
+	averager   *rate.Averager
+	hosts      []Host
+	threshold  decimal.Decimal
+	guardrails Guardrails
+	prices     Prices
+	lastRate   decimal.Decimal
+	hostStatus map[string]HostStatus
+}
+
+// NewPinner creates a new Pinner.
+func NewPinner(hosts []Host, prices Prices, threshold decimal.Decimal, guardrails Guardrails, averager *rate.Averager, log *zap.Logger) *Pinner {
+	return &Pinner{
+		log:        log,
+		averager:   averager,
+		hosts:      hosts,
+		threshold:  threshold,
+		guardrails: guardrails,
+		prices:     prices,
+		hostStatus: make(map[string]HostStatus, len(hosts)),
+	}
+}
+
+// Prices returns the daemon's current default target prices.
+func (p *Pinner) Prices() Prices {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.prices
+}
+
+// SetPrices hot-reloads the daemon's default target prices without
+// requiring a restart.
+func (p *Pinner) SetPrices(prices Prices) {
+	p.mu.Lock()
+	p.prices = prices
+	p.mu.Unlock()
+}
+
+// SetThreshold hot-reloads the update threshold used by Run.
+func (p *Pinner) SetThreshold(threshold decimal.Decimal) {
+	p.mu.Lock()
+	p.threshold = threshold
+	p.mu.Unlock()
+}
+
+// SetGuardrails hot-reloads the Guardrails applied to every host update.
+func (p *Pinner) SetGuardrails(guardrails Guardrails) {
+	p.mu.Lock()
+	p.guardrails = guardrails
+	p.mu.Unlock()
+}
+
+// SetHosts hot-reloads the fleet of hosts to pin, adding and removing
+// hosts as needed without disturbing the recorded status of hosts that
+// are kept.
+func (p *Pinner) SetHosts(hosts []Host) {
+	kept := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		kept[h.Address] = true
+	}
+
+	p.mu.Lock()
+	p.hosts = hosts
+	for addr := range p.hostStatus {
+		if !kept[addr] {
+			delete(p.hostStatus, addr)
+		}
+	}
+	p.mu.Unlock()
+}
+
+// SetAverager swaps the rate.Averager the Pinner reads the exchange rate
+// from. Callers are responsible for starting and stopping the new and
+// old averager's Run loop.
+func (p *Pinner) SetAverager(averager *rate.Averager) {
+	p.mu.Lock()
+	p.averager = averager
+	p.mu.Unlock()
+}
+
+// state returns a consistent snapshot of the fields updateHosts and Run
+// need, so the lock isn't held for the duration of a network round trip
+// to every host.
+func (p *Pinner) state() (averager *rate.Averager, hosts []Host, threshold decimal.Decimal, guardrails Guardrails) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.averager, p.hosts, p.threshold, p.guardrails
+}
+
+// RateHistory returns the persisted exchange rate samples observed at or
+// after since, for charting historical pricing.
+func (p *Pinner) RateHistory(since time.Time) ([]rate.Sample, error) {
+	averager, _, _, _ := p.state()
+	return averager.History(since)
+}
+
+// Status returns a snapshot of the daemon's current rate, average, and
+// per-host update state.
+func (p *Pinner) Status() Status {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hosts := make([]HostStatus, 0, len(p.hostStatus))
+	for _, hs := range p.hostStatus {
+		hosts = append(hosts, hs)
+	}
+	average, err := p.averager.Rate()
+	return Status{
+		Rate:      p.lastRate,
+		Average:   average,
+		RateReady: err == nil,
+		Hosts:     hosts,
+	}
+}
+
+// updateHosts pushes target (or each host's override) to every configured
+// host at the given exchange rate, recording the outcome of each
+// UpdateSettings call in hostStatus. Target prices outside the configured
+// MinPrices and MaxPrices are clamped to those bounds and still pushed;
+// an update that would exceed MaxChangePercent is skipped rather than
+// pushed, and in DryRun mode no UpdateSettings call is made at all.
+func (p *Pinner) updateHosts(target Prices, exchangeRate decimal.Decimal) error {
+	_, hosts, _, guardrails := p.state()
+
+	var updateErr error
+	for _, h := range hosts {
+		hostTarget := target
+		if h.Prices != nil {
+			hostTarget = *h.Prices
+		}
+		hostTarget = clampPrices(hostTarget, guardrails.MinPrices, guardrails.MaxPrices)
+		converted := Convert(hostTarget, exchangeRate)
+
+		client := api.NewClient(h.Address, h.Password)
+
+		if !guardrails.MaxChangePercent.IsZero() {
+			current, err := client.Settings()
+			if err != nil {
+				p.log.Warn("failed to fetch current host settings, skipping", zap.String("host", h.Address), zap.Error(err))
+				continue
+			}
+			if exceedsMaxChange(convertedFromSettings(current), converted, guardrails.MaxChangePercent) {
+				p.log.Warn("update exceeds max change percent, skipping host", zap.String("host", h.Address))
+				continue
+			}
+		}
+
+		if guardrails.DryRun {
+			p.log.Info("dry run: would update host", zap.String("host", h.Address), zap.Stringer("rate", exchangeRate),
+				zap.Stringer("storage", converted.Storage), zap.Stringer("ingress", converted.Ingress), zap.Stringer("egress", converted.Egress),
+				zap.Stringer("collateral", converted.Collateral), zap.Stringer("maxCollateral", converted.MaxCollateral),
+				zap.Stringer("contractPrice", converted.ContractPrice), zap.Stringer("baseRPCPrice", converted.BaseRPCPrice),
+				zap.Stringer("sectorAccessPrice", converted.SectorAccessPrice))
+			continue
+		}
+
+		status := HostStatus{Address: h.Address, LastUpdate: time.Now(), Prices: converted}
+		p.mu.Lock()
+		prev := p.hostStatus[h.Address]
+		status.Successes, status.Failures = prev.Successes, prev.Failures
+		p.mu.Unlock()
+
+		_, err := client.UpdateSettings(
+			api.SetMinStoragePrice(converted.Storage),
+			api.SetMinIngressPrice(converted.Ingress),
+			api.SetMinEgressPrice(converted.Egress),
+			setCollateralMultiplier(converted.Collateral),
+			api.SetMaxCollateral(converted.MaxCollateral),
+			api.SetContractPrice(converted.ContractPrice),
+			api.SetBaseRPCPrice(converted.BaseRPCPrice),
+			api.SetSectorAccessPrice(converted.SectorAccessPrice),
+		)
+		if err != nil {
+			status.Error = err.Error()
+			status.Failures++
+			updateErr = fmt.Errorf("failed to update host %q: %w", h.Address, err)
+			p.log.Error("failed to update host", zap.String("host", h.Address), zap.Error(err))
+		} else {
+			status.Successes++
+			p.log.Debug("updated host", zap.String("host", h.Address), zap.Stringer("rate", exchangeRate))
+		}
+
+		p.mu.Lock()
+		p.hostStatus[h.Address] = status
+		p.mu.Unlock()
+	}
+	return updateErr
+}
+
+// setCollateralMultiplier sets the host's CollateralMultiplier, the
+// dimensionless ratio of storage price hostd uses to size per-sector
+// collateral. hostd's api package has no typed option for it, so this
+// mirrors the pattern of api.SetContractPrice et al. directly against
+// the "collateralMultiplier" JSON field settings.Settings exposes.
+func setCollateralMultiplier(multiplier decimal.Decimal) api.Setting {
+	f, _ := multiplier.Float64()
+	return func(v map[string]any) {
+		v["collateralMultiplier"] = f
+	}
+}
+
+// convertedFromSettings translates a host's current on-chain settings
+// into ConvertedPrices so they can be compared against a pending update.
+func convertedFromSettings(s settings.Settings) ConvertedPrices {
+	return ConvertedPrices{
+		Storage:           s.StoragePrice,
+		Ingress:           s.IngressPrice,
+		Egress:            s.EgressPrice,
+		Collateral:        decimal.NewFromFloat(s.CollateralMultiplier),
+		MaxCollateral:     s.MaxCollateral,
+		ContractPrice:     s.ContractPrice,
+		BaseRPCPrice:      s.BaseRPCPrice,
+		SectorAccessPrice: s.SectorAccessPrice,
+	}
+}
+
+// Repin immediately pushes the current target prices to every configured
+// host at the current average exchange rate, regardless of the update
+// threshold. Like Init and Run, it refuses to push a price update until
+// the averager has collected its configured minimum number of samples.
+func (p *Pinner) Repin() error {
+	averager, _, _, _ := p.state()
+
+	average, err := averager.Rate()
+	if err != nil {
+		return fmt.Errorf("failed to get exchange rate: %w", err)
+	}
+	return p.updateHosts(p.Prices(), average)
+}
+
+// Init fetches the initial exchange rate, establishing the baseline rate
+// used for threshold comparisons. It only pushes an initial price update
+// once the averager's MinRateSamples requirement is satisfied, so a
+// single bogus first tick can't immediately propagate to every host.
+func (p *Pinner) Init() (decimal.Decimal, error) {
+	averager, _, _, _ := p.state()
+
+	exchangeRate, err := averager.Update()
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get initial exchange rate: %w", err)
+	}
+
+	p.mu.Lock()
+	p.lastRate = exchangeRate
+	p.mu.Unlock()
+
+	average, err := averager.Rate()
+	if err != nil {
+		p.log.Warn("skipping initial host update: not enough rate samples collected yet", zap.Error(err))
+		return exchangeRate, nil
+	}
+	return exchangeRate, p.updateHosts(p.Prices(), average)
+}
+
+// Run polls the averager at frequency and pushes updated prices to every
+// host whenever the average exchange rate has moved beyond the
+// configured threshold relative to the last pinned rate.
+func (p *Pinner) Run(ctx context.Context, frequency time.Duration) {
+	t := time.NewTicker(frequency)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			averager, _, threshold, _ := p.state()
+
+			average, err := averager.Rate()
+			if err != nil {
+				p.log.Debug("skipping update: not enough rate samples collected yet", zap.Error(err))
+				continue
+			}
+
+			p.mu.Lock()
+			last := p.lastRate
+			p.mu.Unlock()
+
+			if !isOverThreshold(last, average, threshold) {
+				p.log.Debug("skipping update", zap.Stringer("old", last), zap.Stringer("new", average))
+				continue
+			}
+
+			p.mu.Lock()
+			p.lastRate = average
+			p.mu.Unlock()
+
+			if err := p.updateHosts(p.Prices(), average); err != nil {
+				p.log.Error("failed to update hosts", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
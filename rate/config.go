@@ -3,6 +3,7 @@ package rate
 import (
 	"time"
 
+	"github.com/shopspring/decimal"
 	"go.uber.org/zap"
 )
 
@@ -29,3 +30,65 @@ func WithLogger(log *zap.Logger) Option {
 		ra.log = log
 	}
 }
+
+// WithSources sets the upstream price sources the averager will query on
+// each tick. If not set, the averager defaults to a single SiaCentral
+// source.
+func WithSources(sources ...WeightedSource) Option {
+	return func(ra *Averager) {
+		ra.sources = sources
+	}
+}
+
+// WithSourceTimeout sets the per-source timeout used when querying
+// sources for a new sample.
+func WithSourceTimeout(timeout time.Duration) Option {
+	return func(ra *Averager) {
+		ra.sourceTimeout = timeout
+	}
+}
+
+// WithMADMultiplier sets the median absolute deviation multiplier used to
+// reject outlier samples before they are averaged. A sample is discarded
+// if its distance from the median exceeds the MAD of all samples
+// multiplied by this value.
+func WithMADMultiplier(multiplier decimal.Decimal) Option {
+	return func(ra *Averager) {
+		ra.madMultiplier = multiplier
+	}
+}
+
+// WithStore sets the Store used to persist rate samples so the moving
+// average window survives restarts. If not set, the averager keeps
+// history in memory only.
+func WithStore(store Store) Option {
+	return func(ra *Averager) {
+		ra.store = store
+	}
+}
+
+// WithStrategy sets the Strategy used to compute the moving average from
+// the window of historical samples. If not set, the averager uses
+// SMAStrategy.
+func WithStrategy(strategy Strategy) Option {
+	return func(ra *Averager) {
+		ra.strategy = strategy
+	}
+}
+
+// WithWindow sets the span of history kept for the moving average. If
+// not set, the averager defaults to 48 hours.
+func WithWindow(window time.Duration) Option {
+	return func(ra *Averager) {
+		ra.window = window
+	}
+}
+
+// WithMinSamples sets the minimum number of ticks that must be collected
+// before Rate returns a value instead of ErrInsufficientSamples. If not
+// set, the averager defaults to 1.
+func WithMinSamples(minSamples int) Option {
+	return func(ra *Averager) {
+		ra.minSamples = minSamples
+	}
+}
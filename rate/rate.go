@@ -2,63 +2,257 @@ package rate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/shopspring/decimal"
-	"github.com/siacentral/apisdkgo"
 	"go.uber.org/zap"
 )
 
-func getExchangeRate(currency string) (decimal.Decimal, error) {
-	sc := apisdkgo.NewSiaClient()
-	rates, _, err := sc.GetExchangeRate()
-	if err != nil {
-		return decimal.Zero, err
-	}
-	rate, ok := rates[currency]
-	if !ok {
-		return decimal.Zero, fmt.Errorf("currency not found")
-	}
-	return decimal.NewFromFloat(rate), nil
-}
+// defaultMADMultiplier is the default median absolute deviation multiplier
+// used to reject outlier samples.
+var defaultMADMultiplier = decimal.NewFromInt(3)
+
+// defaultWindow is the default span of history kept for the moving
+// average when no window is configured.
+const defaultWindow = 48 * time.Hour
+
+// ErrInsufficientSamples is returned by Rate when fewer than the
+// configured minimum number of ticks have been collected. It guards
+// against a bogus first exchange rate tick propagating to every host
+// before the moving average has had a chance to smooth it out.
+var ErrInsufficientSamples = errors.New("insufficient rate samples collected")
 
 // Averager tracks the average exchange rate for a currency over a period
 // of time.
 type Averager struct {
 	log *zap.Logger
 
-	currency  string
-	frequency time.Duration
+	currency      string
+	frequency     time.Duration
+	sources       []WeightedSource
+	sourceTimeout time.Duration
+	madMultiplier decimal.Decimal
+	store         Store
+	strategy      Strategy
+	window        time.Duration
+	minSamples    int
+
+	mu          sync.Mutex // protects history, average, and updateCount
+	history     []Sample
+	average     decimal.Decimal
+	updateCount int
+}
+
+// sample is a single exchange rate observation from a source.
+type sample struct {
+	source string
+	weight decimal.Decimal
+	rate   decimal.Decimal
+}
+
+// fetchSamples queries every configured source concurrently, applying the
+// averager's per-source timeout, and returns the samples from the sources
+// that responded successfully.
+func (ra *Averager) fetchSamples(ctx context.Context) []sample {
+	var wg sync.WaitGroup
+	samplesCh := make(chan *sample, len(ra.sources))
+
+	for _, ws := range ra.sources {
+		wg.Add(1)
+		go func(ws WeightedSource) {
+			defer wg.Done()
+
+			fetchCtx, cancel := context.WithTimeout(ctx, ra.sourceTimeout)
+			defer cancel()
+
+			rate, err := ws.Source.Fetch(fetchCtx, ra.currency)
+			if err != nil {
+				ra.log.Warn("failed to fetch exchange rate", zap.String("source", ws.Source.Name()), zap.Error(err))
+				samplesCh <- nil
+				return
+			}
+			samplesCh <- &sample{source: ws.Source.Name(), weight: ws.Weight, rate: rate}
+		}(ws)
+	}
+
+	go func() {
+		wg.Wait()
+		close(samplesCh)
+	}()
+
+	var samples []sample
+	for s := range samplesCh {
+		if s != nil {
+			samples = append(samples, *s)
+		}
+	}
+	return samples
+}
+
+// aggregate computes a robust cross-source price from samples: it takes
+// the median rate, discards any sample whose deviation from the median
+// exceeds the median absolute deviation multiplied by madMultiplier, and
+// returns the weighted average of the remaining samples along with the
+// samples that survived rejection. Callers must persist and average over
+// the returned samples rather than the original ones, or a single
+// manipulated or broken source flows straight through unfiltered.
+func aggregate(samples []sample, madMultiplier decimal.Decimal) (decimal.Decimal, []sample, error) {
+	if len(samples) == 0 {
+		return decimal.Zero, nil, errors.New("no samples")
+	} else if len(samples) == 1 {
+		return samples[0].rate, samples, nil
+	}
+
+	sorted := make([]decimal.Decimal, len(samples))
+	for i, s := range samples {
+		sorted[i] = s.rate
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+	median := medianOf(sorted)
+
+	deviations := make([]decimal.Decimal, len(samples))
+	for i, s := range samples {
+		deviations[i] = s.rate.Sub(median).Abs()
+	}
+	sortedDeviations := make([]decimal.Decimal, len(deviations))
+	copy(sortedDeviations, deviations)
+	sort.Slice(sortedDeviations, func(i, j int) bool { return sortedDeviations[i].LessThan(sortedDeviations[j]) })
+	mad := medianOf(sortedDeviations)
+	threshold := mad.Mul(madMultiplier)
+
+	var accepted []sample
+	var weightedSum, totalWeight decimal.Decimal
+	for i, s := range samples {
+		// a zero MAD means a majority of samples equal the median exactly;
+		// do not reject in that case since the threshold would also be
+		// zero, so every sample is accepted.
+		if !mad.IsZero() && deviations[i].GreaterThan(threshold) {
+			continue
+		}
+		accepted = append(accepted, s)
+		weightedSum = weightedSum.Add(s.rate.Mul(s.weight))
+		totalWeight = totalWeight.Add(s.weight)
+	}
+	if totalWeight.IsZero() {
+		// every sample was rejected as an outlier; none can be trusted
+		// over another, so fall back to the median and persist all of
+		// them rather than returning an error or discarding everything.
+		return median, samples, nil
+	}
+	return weightedSum.Div(totalWeight), accepted, nil
+}
 
-	mu      sync.Mutex // protects rates
-	rates   []decimal.Decimal
-	average decimal.Decimal
+// medianOf returns the median of a sorted slice of decimals.
+func medianOf(sorted []decimal.Decimal) decimal.Decimal {
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return sorted[n/2-1].Add(sorted[n/2]).Div(decimal.NewFromInt(2))
 }
 
-// Update updates the average exchange rate for the configured currency.
+// Update queries all configured sources for the current exchange rate,
+// persists the samples that survive MAD outlier rejection, and
+// recomputes the moving average using the configured Strategy over that
+// same filtered history. It returns the aggregated spot rate for this
+// tick.
 func (ra *Averager) Update() (decimal.Decimal, error) {
-	rate, err := getExchangeRate(ra.currency)
+	ctx, cancel := context.WithTimeout(context.Background(), ra.sourceTimeout)
+	defer cancel()
+
+	samples := ra.fetchSamples(ctx)
+	if len(samples) == 0 {
+		return decimal.Zero, fmt.Errorf("failed to get exchange rate: all sources failed")
+	}
+
+	spot, accepted, err := aggregate(samples, ra.madMultiplier)
 	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get exchange rate: %w", err)
+		return decimal.Zero, fmt.Errorf("failed to aggregate exchange rate: %w", err)
 	}
 
-	maxRates := int(48 * time.Hour / ra.frequency)
+	now := time.Now()
+	for _, s := range accepted {
+		if ra.store == nil {
+			continue
+		}
+		if err := ra.store.AddSample(Sample{Timestamp: now, Currency: ra.currency, Source: s.source, Rate: s.rate}); err != nil {
+			ra.log.Warn("failed to persist rate sample", zap.String("source", s.source), zap.Error(err))
+		}
+	}
 
 	ra.mu.Lock()
-	ra.rates = append(ra.rates, rate)
-	if len(ra.rates) > maxRates {
-		ra.rates = ra.rates[1:]
+	for _, s := range accepted {
+		ra.history = append(ra.history, Sample{Timestamp: now, Currency: ra.currency, Source: s.source, Rate: s.rate})
+	}
+	ra.trimHistory(now)
+	ra.recomputeAverage()
+	ra.updateCount++
+	ra.log.Debug("exchange rate updated", zap.Stringer("current", spot), zap.Stringer("average", ra.average), zap.Int("samples", len(samples)), zap.Int("accepted", len(accepted)))
+	ra.mu.Unlock()
+	return spot, nil
+}
+
+// trimHistory drops samples older than the configured window. Callers
+// must hold ra.mu.
+func (ra *Averager) trimHistory(now time.Time) {
+	cutoff := now.Add(-ra.window)
+	trimmed := ra.history[:0]
+	for _, s := range ra.history {
+		if s.Timestamp.After(cutoff) {
+			trimmed = append(trimmed, s)
+		}
+	}
+	ra.history = trimmed
+}
+
+// recomputeAverage recomputes ra.average using the configured Strategy.
+// Callers must hold ra.mu.
+func (ra *Averager) recomputeAverage() {
+	average, err := ra.strategy.Compute(ra.currency, ra.history)
+	if err != nil {
+		ra.log.Debug("unable to compute moving average", zap.Error(err))
+		return
 	}
-	sum := decimal.Zero
-	for _, r := range ra.rates {
-		sum = sum.Add(r)
+	ra.average = average
+}
+
+// loadHistory seeds the in-memory window from the configured Store, so a
+// restart doesn't reset the smoothing window.
+func (ra *Averager) loadHistory() {
+	since := time.Now().Add(-ra.window)
+	samples, err := ra.store.Samples(ra.currency, since)
+	if err != nil {
+		ra.log.Error("failed to load rate history", zap.Error(err))
+		return
 	}
-	ra.average = sum.Div(decimal.NewFromInt(int64(len(ra.rates))))
-	ra.log.Debug("exchange rate updated", zap.Stringer("current", rate), zap.Stringer("average", ra.average))
+
+	ra.mu.Lock()
+	ra.history = samples
+	ra.recomputeAverage()
 	ra.mu.Unlock()
-	return rate, nil
+}
+
+// History returns the persisted rate samples for the configured currency
+// observed at or after since. If no Store is configured, it returns the
+// samples currently held in the in-memory window.
+func (ra *Averager) History(since time.Time) ([]Sample, error) {
+	if ra.store != nil {
+		return ra.store.Samples(ra.currency, since)
+	}
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	var out []Sample
+	for _, s := range ra.history {
+		if !s.Timestamp.Before(since) {
+			out = append(out, s)
+		}
+	}
+	return out, nil
 }
 
 // Run starts the averager, which will update the average exchange rate
@@ -80,22 +274,36 @@ func (ra *Averager) Run(ctx context.Context) {
 	}
 }
 
-// Rate returns the average exchange rate for the configured currency.
-func (ra *Averager) Rate() decimal.Decimal {
+// Rate returns the average exchange rate for the configured currency. It
+// returns ErrInsufficientSamples until at least minSamples ticks have
+// been collected.
+func (ra *Averager) Rate() (decimal.Decimal, error) {
 	ra.mu.Lock()
 	defer ra.mu.Unlock()
-	return ra.average
+	if ra.updateCount < ra.minSamples {
+		return decimal.Zero, ErrInsufficientSamples
+	}
+	return ra.average, nil
 }
 
 // New creates a new averager with the provided options.
 func New(opts ...Option) *Averager {
 	a := &Averager{
-		log:       zap.NewNop(),
-		currency:  "usd",
-		frequency: 5 * time.Minute,
+		log:           zap.NewNop(),
+		currency:      "usd",
+		frequency:     5 * time.Minute,
+		sourceTimeout: defaultSourceTimeout,
+		madMultiplier: defaultMADMultiplier,
+		sources:       []WeightedSource{{Source: SiaCentralSource{}, Weight: decimal.NewFromInt(1)}},
+		strategy:      SMAStrategy{},
+		window:        defaultWindow,
+		minSamples:    1,
 	}
 	for _, opt := range opts {
 		opt(a)
 	}
+	if a.store != nil {
+		a.loadHistory()
+	}
 	return a
 }
@@ -0,0 +1,251 @@
+package rate
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.uber.org/zap"
+)
+
+func mustDecimal(s string) decimal.Decimal {
+	return decimal.RequireFromString(s)
+}
+
+// fakeSource is a Source that always returns a fixed rate, used to drive
+// Averager.Update in tests without hitting a real upstream API.
+type fakeSource struct {
+	name string
+	rate decimal.Decimal
+}
+
+// Name implements Source.
+func (f fakeSource) Name() string { return f.name }
+
+// Fetch implements Source.
+func (f fakeSource) Fetch(ctx context.Context, currency string) (decimal.Decimal, error) {
+	return f.rate, nil
+}
+
+// TestAveragerUpdateRejectsOutlier is an end-to-end check that a
+// manipulated or broken source's raw rate never reaches Rate(): it
+// exercises Update and Rate together, rather than aggregate in isolation,
+// so it catches regressions where the filtered result is discarded before
+// it's persisted or averaged.
+func TestAveragerUpdateRejectsOutlier(t *testing.T) {
+	ra := New(
+		WithSources(
+			WeightedSource{Source: fakeSource{name: "a", rate: mustDecimal("9")}, Weight: decimal.NewFromInt(1)},
+			WeightedSource{Source: fakeSource{name: "b", rate: mustDecimal("10")}, Weight: decimal.NewFromInt(1)},
+			WeightedSource{Source: fakeSource{name: "c", rate: mustDecimal("11")}, Weight: decimal.NewFromInt(1)},
+			WeightedSource{Source: fakeSource{name: "manipulated", rate: mustDecimal("1000")}, Weight: decimal.NewFromInt(1)},
+		),
+		WithLogger(zap.NewNop()),
+	)
+
+	spot, err := ra.Update()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !spot.Equal(mustDecimal("10")) {
+		t.Fatalf("expected spot rate 10, got %s", spot)
+	}
+
+	average, err := ra.Rate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !average.Equal(mustDecimal("10")) {
+		t.Fatalf("expected average rate 10 filtered from the manipulated source, got %s", average)
+	}
+
+	history, err := ra.History(time.Time{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range history {
+		if s.Source == "manipulated" {
+			t.Fatalf("manipulated source's raw sample leaked into history: %+v", s)
+		}
+	}
+	if len(history) != 3 {
+		t.Fatalf("expected 3 accepted samples in history, got %d", len(history))
+	}
+}
+
+// TestBoltStoreSameTickMultipleSources verifies that samples from
+// different sources stamped with the identical timestamp (as Update does
+// for every source in a tick) are stored as distinct records rather than
+// overwriting each other.
+func TestBoltStoreSameTickMultipleSources(t *testing.T) {
+	store, err := NewBoltStore(filepath.Join(t.TempDir(), "rates.db"))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now()
+	for _, s := range []Sample{
+		{Timestamp: now, Currency: "usd", Source: "a", Rate: mustDecimal("9")},
+		{Timestamp: now, Currency: "usd", Source: "b", Rate: mustDecimal("10")},
+		{Timestamp: now, Currency: "usd", Source: "c", Rate: mustDecimal("11")},
+	} {
+		if err := store.AddSample(s); err != nil {
+			t.Fatalf("failed to add sample: %v", err)
+		}
+	}
+
+	samples, err := store.Samples("usd", now.Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("failed to read samples: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("expected 3 samples, got %d", len(samples))
+	}
+
+	seen := make(map[string]bool)
+	for _, s := range samples {
+		seen[s.Source] = true
+	}
+	for _, source := range []string{"a", "b", "c"} {
+		if !seen[source] {
+			t.Fatalf("sample from source %q was lost", source)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	tests := []struct {
+		name          string
+		samples       []sample
+		madMultiplier decimal.Decimal
+		want          decimal.Decimal
+		wantAccepted  int
+		wantErr       bool
+	}{
+		{
+			name:    "no samples",
+			samples: nil,
+			wantErr: true,
+		},
+		{
+			name: "single source",
+			samples: []sample{
+				{source: "a", weight: decimal.NewFromInt(1), rate: mustDecimal("1.5")},
+			},
+			madMultiplier: defaultMADMultiplier,
+			want:          mustDecimal("1.5"),
+			wantAccepted:  1,
+		},
+		{
+			name: "two sources, no outlier",
+			samples: []sample{
+				{source: "a", weight: decimal.NewFromInt(1), rate: mustDecimal("1")},
+				{source: "b", weight: decimal.NewFromInt(1), rate: mustDecimal("3")},
+			},
+			madMultiplier: defaultMADMultiplier,
+			want:          mustDecimal("2"),
+			wantAccepted:  2,
+		},
+		{
+			name: "unanimous sources",
+			samples: []sample{
+				{source: "a", weight: decimal.NewFromInt(1), rate: mustDecimal("1")},
+				{source: "b", weight: decimal.NewFromInt(1), rate: mustDecimal("1")},
+				{source: "c", weight: decimal.NewFromInt(1), rate: mustDecimal("1")},
+			},
+			madMultiplier: defaultMADMultiplier,
+			want:          mustDecimal("1"),
+			wantAccepted:  3,
+		},
+		{
+			name: "one outlier rejected",
+			samples: []sample{
+				{source: "a", weight: decimal.NewFromInt(1), rate: mustDecimal("9")},
+				{source: "b", weight: decimal.NewFromInt(1), rate: mustDecimal("10")},
+				{source: "c", weight: decimal.NewFromInt(1), rate: mustDecimal("11")},
+				{source: "d", weight: decimal.NewFromInt(1), rate: mustDecimal("1000")},
+			},
+			madMultiplier: defaultMADMultiplier,
+			want:          mustDecimal("10"),
+			wantAccepted:  3,
+		},
+		{
+			name: "all sources rejected falls back to median",
+			samples: []sample{
+				{source: "a", weight: decimal.NewFromInt(1), rate: mustDecimal("1")},
+				{source: "b", weight: decimal.NewFromInt(1), rate: mustDecimal("2")},
+				{source: "c", weight: decimal.NewFromInt(1), rate: mustDecimal("3")},
+				{source: "d", weight: decimal.NewFromInt(1), rate: mustDecimal("4")},
+			},
+			madMultiplier: decimal.Zero,
+			want:          mustDecimal("2.5"),
+			wantAccepted:  4,
+		},
+		{
+			name: "weighted average favors higher weight",
+			samples: []sample{
+				{source: "a", weight: decimal.NewFromInt(3), rate: mustDecimal("1")},
+				{source: "b", weight: decimal.NewFromInt(1), rate: mustDecimal("5")},
+			},
+			madMultiplier: defaultMADMultiplier,
+			want:          mustDecimal("2"),
+			wantAccepted:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, accepted, err := aggregate(tt.samples, tt.madMultiplier)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+			if len(accepted) != tt.wantAccepted {
+				t.Fatalf("expected %d accepted samples, got %d", tt.wantAccepted, len(accepted))
+			}
+		})
+	}
+}
+
+func TestMedianOf(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []decimal.Decimal
+		want   decimal.Decimal
+	}{
+		{
+			name:   "odd length",
+			sorted: []decimal.Decimal{mustDecimal("1"), mustDecimal("2"), mustDecimal("3")},
+			want:   mustDecimal("2"),
+		},
+		{
+			name:   "even length",
+			sorted: []decimal.Decimal{mustDecimal("1"), mustDecimal("2"), mustDecimal("3"), mustDecimal("4")},
+			want:   mustDecimal("2.5"),
+		},
+		{
+			name:   "single element",
+			sorted: []decimal.Decimal{mustDecimal("7")},
+			want:   mustDecimal("7"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := medianOf(tt.sorted); !got.Equal(tt.want) {
+				t.Fatalf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
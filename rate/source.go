@@ -0,0 +1,234 @@
+package rate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/siacentral/apisdkgo"
+)
+
+// A Source fetches the current exchange rate for a currency from an
+// upstream price feed.
+type Source interface {
+	// Name returns a human-readable identifier for the source, used in
+	// logs and metrics.
+	Name() string
+	// Fetch returns the current exchange rate for currency.
+	Fetch(ctx context.Context, currency string) (decimal.Decimal, error)
+}
+
+// A WeightedSource pairs a Source with its weight in the aggregate price
+// calculation. Sources with a higher weight contribute more to the final
+// averaged rate.
+type WeightedSource struct {
+	Source Source
+	Weight decimal.Decimal
+}
+
+// SiaCentralSource fetches the exchange rate from the SiaCentral API.
+type SiaCentralSource struct{}
+
+// Name implements Source.
+func (SiaCentralSource) Name() string { return "siacentral" }
+
+// Fetch implements Source.
+func (SiaCentralSource) Fetch(ctx context.Context, currency string) (decimal.Decimal, error) {
+	sc := apisdkgo.NewSiaClient()
+	rates, _, err := sc.GetExchangeRate()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	rate, ok := rates[currency]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("currency %q not found", currency)
+	}
+	return decimal.NewFromFloat(rate), nil
+}
+
+// CoinGeckoSource fetches the exchange rate from the CoinGecko simple price
+// API.
+type CoinGeckoSource struct {
+	// Client is the HTTP client used to query CoinGecko. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Name implements Source.
+func (CoinGeckoSource) Name() string { return "coingecko" }
+
+// Fetch implements Source.
+func (cg CoinGeckoSource) Fetch(ctx context.Context, currency string) (decimal.Decimal, error) {
+	url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/price?ids=siacoin&vs_currencies=%s", strings.ToLower(currency))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	resp, err := cg.client().Do(req)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer resp.Body.Close()
+
+	var body map[string]map[string]decimal.Decimal
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	rate, ok := body["siacoin"][strings.ToLower(currency)]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("currency %q not found", currency)
+	}
+	return rate, nil
+}
+
+func (cg CoinGeckoSource) client() *http.Client {
+	if cg.Client == nil {
+		return http.DefaultClient
+	}
+	return cg.Client
+}
+
+// KrakenSource fetches the exchange rate from the Kraken ticker API. Kraken
+// only quotes SC against a handful of fiat currencies; Pair must be set to
+// the Kraken pair name (e.g. "SCUSD").
+type KrakenSource struct {
+	// Pair is the Kraken trading pair to query, e.g. "SCUSD".
+	Pair string
+	// Client is the HTTP client used to query Kraken. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Name implements Source.
+func (k KrakenSource) Name() string { return "kraken" }
+
+// Fetch implements Source.
+func (k KrakenSource) Fetch(ctx context.Context, currency string) (decimal.Decimal, error) {
+	url := fmt.Sprintf("https://api.kraken.com/0/public/Ticker?pair=%s", k.Pair)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	resp, err := k.client().Do(req)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Error  []string `json:"error"`
+		Result map[string]struct {
+			C []string `json:"c"` // last trade closed [price, lot volume]
+		} `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to decode response: %w", err)
+	} else if len(body.Error) != 0 {
+		return decimal.Zero, fmt.Errorf("kraken error: %s", strings.Join(body.Error, "; "))
+	}
+
+	ticker, ok := body.Result[k.Pair]
+	if !ok || len(ticker.C) == 0 {
+		return decimal.Zero, fmt.Errorf("pair %q not found", k.Pair)
+	}
+	return decimal.NewFromString(ticker.C[0])
+}
+
+func (k KrakenSource) client() *http.Client {
+	if k.Client == nil {
+		return http.DefaultClient
+	}
+	return k.Client
+}
+
+// HTTPSource is a generic JSON source for prices exposed by APIs without a
+// dedicated implementation. Field is a JSONPath-style selector (e.g.
+// "data.price" or "rates.0.value") identifying the numeric field to read
+// from the decoded response body.
+type HTTPSource struct {
+	// URL is the endpoint to query. It is queried as-is; any currency
+	// selection must already be encoded in the URL.
+	URL string
+	// Field is the dot-separated path to the price field in the decoded
+	// JSON response. Array elements are addressed by index, e.g.
+	// "data.0.price".
+	Field string
+	// Client is the HTTP client used to query URL. If nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Name implements Source.
+func (h HTTPSource) Name() string { return "http:" + h.URL }
+
+// Fetch implements Source.
+func (h HTTPSource) Fetch(ctx context.Context, currency string) (decimal.Decimal, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL, nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	resp, err := h.client().Do(req)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	defer resp.Body.Close()
+
+	var body any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return decimal.Zero, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return selectField(body, h.Field)
+}
+
+func (h HTTPSource) client() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+	return h.Client
+}
+
+// selectField walks a decoded JSON value following a dot-separated path and
+// returns the numeric value found there.
+func selectField(v any, path string) (decimal.Decimal, error) {
+	cur := v
+	for _, key := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return decimal.Zero, fmt.Errorf("field %q not found", key)
+			}
+			cur = next
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return decimal.Zero, fmt.Errorf("invalid array index %q", key)
+			}
+			cur = node[idx]
+		default:
+			return decimal.Zero, fmt.Errorf("cannot index into field %q", key)
+		}
+	}
+
+	switch n := cur.(type) {
+	case float64:
+		return decimal.NewFromFloat(n), nil
+	case string:
+		return decimal.NewFromString(n)
+	default:
+		return decimal.Zero, fmt.Errorf("field %q is not numeric", path)
+	}
+}
+
+// defaultSourceTimeout is the per-source fetch timeout used when none is
+// configured.
+const defaultSourceTimeout = 10 * time.Second
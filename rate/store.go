@@ -0,0 +1,28 @@
+package rate
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// A Sample is a single exchange rate observation, as reported by one
+// source at one point in time.
+type Sample struct {
+	Timestamp time.Time
+	Currency  string
+	Source    string
+	Rate      decimal.Decimal
+}
+
+// A Store persists exchange rate samples so the moving-average window
+// survives daemon restarts.
+type Store interface {
+	// AddSample persists a new sample.
+	AddSample(s Sample) error
+	// Samples returns the samples for currency observed at or after
+	// since, ordered by timestamp ascending.
+	Samples(currency string, since time.Time) ([]Sample, error)
+	// Close releases any resources held by the store.
+	Close() error
+}
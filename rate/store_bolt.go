@@ -0,0 +1,111 @@
+package rate
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"go.etcd.io/bbolt"
+)
+
+var rateSamplesBucket = []byte("rateSamples")
+
+// BoltStore persists rate samples to a BoltDB database.
+type BoltStore struct {
+	db *bbolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) a BoltDB-backed Store at
+// path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(rateSamplesBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// boltSample is the value stored for each sample key. The key itself
+// encodes the currency, timestamp, and source so range scans don't need
+// to decode every value.
+type boltSample struct {
+	Source string `json:"source"`
+	Rate   string `json:"rate"`
+}
+
+// sampleKey builds a lexicographically sortable key consisting of the
+// currency (null-terminated), the big-endian observation timestamp, and
+// the source, so a prefix scan returns a currency's samples in
+// chronological order and same-tick samples from different sources don't
+// collide.
+func sampleKey(currency string, ts time.Time, source string) []byte {
+	key := make([]byte, len(currency)+1+8+len(source))
+	copy(key, currency)
+	binary.BigEndian.PutUint64(key[len(currency)+1:], uint64(ts.UnixNano()))
+	copy(key[len(currency)+1+8:], source)
+	return key
+}
+
+// AddSample implements Store.
+func (b *BoltStore) AddSample(s Sample) error {
+	val, err := json.Marshal(boltSample{Source: s.Source, Rate: s.Rate.String()})
+	if err != nil {
+		return fmt.Errorf("failed to encode sample: %w", err)
+	}
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(rateSamplesBucket).Put(sampleKey(s.Currency, s.Timestamp, s.Source), val)
+	})
+}
+
+// Samples implements Store.
+func (b *BoltStore) Samples(currency string, since time.Time) ([]Sample, error) {
+	prefix := append([]byte(currency), 0)
+	seek := sampleKey(currency, since, "")
+	if since.Before(time.Unix(0, 0)) {
+		// timestamps before the Unix epoch (including the zero time)
+		// don't encode to sortable keys; scan the whole currency prefix.
+		seek = prefix
+	}
+
+	var samples []Sample
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(rateSamplesBucket).Cursor()
+		for k, v := c.Seek(seek); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var bs boltSample
+			if err := json.Unmarshal(v, &bs); err != nil {
+				return fmt.Errorf("failed to decode sample: %w", err)
+			}
+			rate, err := decimal.NewFromString(bs.Rate)
+			if err != nil {
+				return fmt.Errorf("failed to parse rate: %w", err)
+			}
+			ts := int64(binary.BigEndian.Uint64(k[len(prefix) : len(prefix)+8]))
+			samples = append(samples, Sample{
+				Timestamp: time.Unix(0, ts),
+				Currency:  currency,
+				Source:    bs.Source,
+				Rate:      rate,
+			})
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// Close implements Store.
+func (b *BoltStore) Close() error {
+	return b.db.Close()
+}
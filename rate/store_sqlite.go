@@ -0,0 +1,84 @@
+package rate
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLiteStore persists rate samples to a SQLite database.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (creating if necessary) a SQLite-backed Store at
+// path.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS rate_samples (
+		timestamp INTEGER NOT NULL,
+		currency  TEXT NOT NULL,
+		source    TEXT NOT NULL,
+		rate      TEXT NOT NULL
+	)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize schema: %w", err)
+	}
+
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS rate_samples_currency_timestamp ON rate_samples (currency, timestamp)`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create index: %w", err)
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// AddSample implements Store.
+func (s *SQLiteStore) AddSample(sample Sample) error {
+	_, err := s.db.Exec(`INSERT INTO rate_samples (timestamp, currency, source, rate) VALUES (?, ?, ?, ?)`,
+		sample.Timestamp.Unix(), sample.Currency, sample.Source, sample.Rate.String())
+	if err != nil {
+		return fmt.Errorf("failed to insert sample: %w", err)
+	}
+	return nil
+}
+
+// Samples implements Store.
+func (s *SQLiteStore) Samples(currency string, since time.Time) ([]Sample, error) {
+	rows, err := s.db.Query(`SELECT timestamp, source, rate FROM rate_samples WHERE currency = ? AND timestamp >= ? ORDER BY timestamp ASC`,
+		currency, since.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []Sample
+	for rows.Next() {
+		var ts int64
+		var rateStr string
+		sample := Sample{Currency: currency}
+		if err := rows.Scan(&ts, &sample.Source, &rateStr); err != nil {
+			return nil, fmt.Errorf("failed to scan sample: %w", err)
+		}
+		sample.Timestamp = time.Unix(ts, 0)
+		sample.Rate, err = decimal.NewFromString(rateStr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse rate: %w", err)
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+// Close implements Store.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,123 @@
+package rate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// A Strategy computes a single aggregated rate from a window of
+// persisted samples for the configured currency.
+type Strategy interface {
+	Compute(currency string, samples []Sample) (decimal.Decimal, error)
+}
+
+// SMAStrategy computes the simple mean of every sample in the window.
+type SMAStrategy struct{}
+
+// Compute implements Strategy.
+func (SMAStrategy) Compute(_ string, samples []Sample) (decimal.Decimal, error) {
+	if len(samples) == 0 {
+		return decimal.Zero, errors.New("no samples")
+	}
+	sum := decimal.Zero
+	for _, s := range samples {
+		sum = sum.Add(s.Rate)
+	}
+	return sum.Div(decimal.NewFromInt(int64(len(samples)))), nil
+}
+
+// EMAStrategy computes an exponential moving average over the window,
+// with Alpha controlling how quickly older samples are discounted.
+type EMAStrategy struct {
+	Alpha decimal.Decimal
+}
+
+// Compute implements Strategy.
+func (e EMAStrategy) Compute(_ string, samples []Sample) (decimal.Decimal, error) {
+	if len(samples) == 0 {
+		return decimal.Zero, errors.New("no samples")
+	}
+
+	sorted := make([]Sample, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	ema := sorted[0].Rate
+	oneMinusAlpha := decimal.NewFromInt(1).Sub(e.Alpha)
+	for _, s := range sorted[1:] {
+		ema = e.Alpha.Mul(s.Rate).Add(oneMinusAlpha.Mul(ema))
+	}
+	return ema, nil
+}
+
+// VWAPStrategy computes a confidence-weighted average, weighting each
+// sample by its source's configured weight. Sources without a configured
+// weight default to 1.
+type VWAPStrategy struct {
+	SourceWeights map[string]decimal.Decimal
+}
+
+// Compute implements Strategy.
+func (v VWAPStrategy) Compute(_ string, samples []Sample) (decimal.Decimal, error) {
+	if len(samples) == 0 {
+		return decimal.Zero, errors.New("no samples")
+	}
+
+	var weightedSum, totalWeight decimal.Decimal
+	for _, s := range samples {
+		weight, ok := v.SourceWeights[s.Source]
+		if !ok {
+			weight = decimal.NewFromInt(1)
+		}
+		weightedSum = weightedSum.Add(s.Rate.Mul(weight))
+		totalWeight = totalWeight.Add(weight)
+	}
+	if totalWeight.IsZero() {
+		return decimal.Zero, errors.New("total source weight is zero")
+	}
+	return weightedSum.Div(totalWeight), nil
+}
+
+// TWAPStrategy computes a time-weighted average over a daily-sampled
+// window spanning Days days, read directly from Store rather than the
+// averager's in-memory window.
+type TWAPStrategy struct {
+	Store Store
+	Days  int
+}
+
+// Compute implements Strategy. It ignores samples and queries Store
+// directly, since the daily-sampled TWAP window is typically much longer
+// than the averager's in-memory window.
+func (t TWAPStrategy) Compute(currency string, _ []Sample) (decimal.Decimal, error) {
+	if t.Store == nil {
+		return decimal.Zero, errors.New("twap strategy requires a store")
+	}
+
+	since := time.Now().AddDate(0, 0, -t.Days)
+	samples, err := t.Store.Samples(currency, since)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to load samples: %w", err)
+	}
+
+	daily := make(map[string]decimal.Decimal)
+	counts := make(map[string]int)
+	for _, s := range samples {
+		day := s.Timestamp.UTC().Format("2006-01-02")
+		daily[day] = daily[day].Add(s.Rate)
+		counts[day]++
+	}
+	if len(daily) == 0 {
+		return decimal.Zero, errors.New("no samples in window")
+	}
+
+	sum := decimal.Zero
+	for day, total := range daily {
+		sum = sum.Add(total.Div(decimal.NewFromInt(int64(counts[day]))))
+	}
+	return sum.Div(decimal.NewFromInt(int64(len(daily)))), nil
+}